@@ -0,0 +1,500 @@
+// Package chat 提供可被多种传输层（TCP、WebSocket……）共用的聊天引擎：
+// 房间（Room）、连接索引（Hub）和客户端（Client）。
+//
+// 这套类型最初写在 tcp/chat/server/02-tcp-chat-server.go 里，随着
+// WebSocket 网关的加入被抽成独立的包，这样 TCP 服务器和 WS 网关就可以
+// 在同一个进程里共享同一份房间状态，而不是各自维护一份互不相通的聊天室。
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kirby980/websocket-demo/history"
+	"github.com/Kirby980/websocket-demo/protocol"
+)
+
+// LobbyName 是每个客户端连接后默认所在的房间
+const LobbyName = "大厅"
+
+// Client 代表一个聊天参与者，不关心底层是 TCP 连接还是 WebSocket 连接——
+// 具体的发送方式由调用方通过 deliver 注入。消息在 msgChan 里以
+// *protocol.Envelope 的形式流转，是否要把它按长度前缀帧写出去，还是按
+// 老的按行文本写出去，由 deliver 决定。
+type Client struct {
+	nameMu sync.RWMutex // 保护 name 的并发读写（/nick 写入，Who/Broadcast/SendMessage 等并发读取）
+	name   string
+
+	msgChan chan *protocol.Envelope
+	deliver func(*protocol.Envelope) error // 把一条消息写到底层连接，TCP/WS 各自实现
+
+	roomMu      sync.Mutex // 保护 currentRoom 的并发切换（/join 与读循环可能并发访问）
+	currentRoom *Room
+
+	closer func() error // 强制关闭底层连接，由传输层在 NewClient 之后通过 SetCloser 注入
+}
+
+// NewClient 创建一个客户端，deliver 负责把消息真正写到底层连接
+// （比如 TCP 的 protocol.WriteEnvelope，或者 WebSocket 的 conn.WriteMessage）。
+func NewClient(name string, deliver func(*protocol.Envelope) error) *Client {
+	return &Client{
+		name:    name,
+		msgChan: make(chan *protocol.Envelope, 100),
+		deliver: deliver,
+	}
+}
+
+// Name 返回客户端当前的昵称，并发安全（/nick 随时可能在另一个 goroutine 里改名）。
+func (c *Client) Name() string {
+	c.nameMu.RLock()
+	defer c.nameMu.RUnlock()
+	return c.name
+}
+
+// setName 修改客户端的昵称，调用方（Hub.handleCommand）负责先通过 Hub.Rename
+// 确认新名字没有被占用。
+func (c *Client) setName(name string) {
+	c.nameMu.Lock()
+	defer c.nameMu.Unlock()
+	c.name = name
+}
+
+// SendMessage 向客户端投递一条文本消息；如果待发队列已满则丢弃，不阻塞调用方。
+// 消息内部会被包成一个 *protocol.Envelope，具体怎么写到线上由 deliver 决定。
+func (c *Client) SendMessage(message string) {
+	env := protocol.NewTextEnvelope("message", c.Name(), "", message, time.Now().Unix())
+	select {
+	case c.msgChan <- env:
+	default:
+		// 队列满，丢弃消息
+	}
+}
+
+// SendReplay 投递一条历史消息。Type 为 "replay"（而不是 SendMessage 用的
+// "message"），这样客户端如果想把历史和实时消息区分显示（比如加个灰色前缀），
+// 可以读 env.Type 来判断；当前的 TCP/WS 客户端都只看 Text()，所以看起来和
+// 普通消息没有区别。
+func (c *Client) SendReplay(env *protocol.Envelope) {
+	replay := *env
+	replay.Type = "replay"
+	select {
+	case c.msgChan <- &replay:
+	default:
+		// 队列满，丢弃消息
+	}
+}
+
+// Ping 投递一条心跳信封，Type 为 "ping"，没有 Body。特意不走 SendMessage，
+// 这样心跳不会被当成一条普通聊天消息显示给用户；支持帧协议的客户端收到后
+// 应当回一条 Type 为 "pong" 的信封，而不是把它打印出来。
+func (c *Client) Ping() {
+	env := &protocol.Envelope{Type: "ping", Timestamp: time.Now().Unix()}
+	select {
+	case c.msgChan <- env:
+	default:
+		// 队列满，丢弃心跳，下一个 ping 周期再试
+	}
+}
+
+// SetCloser 注入一个强制关闭底层连接的函数（比如 TCP 的 conn.Close），
+// 供 Hub.CloseAllConns 在优雅关闭超时后兜底使用。不调用也没关系，Close()
+// 本身已经足够让 WritePump 正常退出。
+func (c *Client) SetCloser(closer func() error) {
+	c.closer = closer
+}
+
+// ForceClose 调用 SetCloser 注入的关闭函数；没注入过就什么都不做。
+func (c *Client) ForceClose() {
+	if c.closer != nil {
+		c.closer()
+	}
+}
+
+// WritePump 持续从 msgChan 取消息并通过 deliver 写出去，直到 msgChan 被关闭
+// 或 deliver 返回错误（通常意味着底层连接已经断开）。
+// 这与 TCP 聊天服务器里的 writePump 是同一个模式，只是写出去的方式可插拔。
+func (c *Client) WritePump() {
+	for msg := range c.msgChan {
+		if err := c.deliver(msg); err != nil {
+			return
+		}
+	}
+}
+
+// Close 关闭客户端的消息队列，之后 WritePump 会在消费完剩余消息后退出。
+func (c *Client) Close() {
+	close(c.msgChan)
+}
+
+// CurrentRoom 返回客户端当前所在的房间
+func (c *Client) CurrentRoom() *Room {
+	c.roomMu.Lock()
+	defer c.roomMu.Unlock()
+	return c.currentRoom
+}
+
+// Room 代表一个聊天房间，拥有一份自己的成员表
+type Room struct {
+	Name    string
+	members sync.Map // key: *Client, value: true
+}
+
+func newRoom(name string) *Room {
+	return &Room{Name: name}
+}
+
+// Join 把客户端加入房间成员表
+func (r *Room) Join(c *Client) {
+	r.members.Store(c, true)
+}
+
+// Leave 把客户端从房间成员表移除
+func (r *Room) Leave(c *Client) {
+	r.members.Delete(c)
+}
+
+// Broadcast 向房间内所有客户端广播消息（可选择排除某个客户端，比如发送者自己）
+func (r *Room) Broadcast(message string, exclude *Client) {
+	r.members.Range(func(key, value any) bool {
+		client := key.(*Client)
+		if client == exclude {
+			return true
+		}
+		client.SendMessage(message)
+		return true
+	})
+}
+
+// MemberCount 返回房间当前人数
+func (r *Room) MemberCount() int {
+	count := 0
+	r.members.Range(func(key, value any) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// Who 返回房间成员名字列表，逗号分隔
+func (r *Room) Who() string {
+	var names []string
+	r.members.Range(func(key, value any) bool {
+		names = append(names, key.(*Client).Name())
+		return true
+	})
+	return strings.Join(names, ", ")
+}
+
+// Hub 管理所有房间，以及一份跨房间、跨传输层的用户名索引
+// （/to 私聊、/nick 查重都要用到）。一个 Hub 实例可以被 TCP 服务器和
+// WebSocket 网关同时持有，这样两边的客户端就在同一个聊天室里。
+type Hub struct {
+	roomsMu sync.RWMutex
+	rooms   map[string]*Room
+
+	indexMu sync.RWMutex
+	index   map[string]*Client // name -> client
+
+	Lobby *Room
+
+	store       history.Store // 每条房间消息落在这里；不需要历史的话传 nil（记录、回放和 /search 都会跳过）
+	replayCount int           // 客户端进入一个房间时回放最近这么多条历史
+}
+
+// NewHub 创建一个带有空大厅的 Hub。store 用于保存/回放历史消息，
+// replayCount 是客户端加入房间时回放的历史条数（<=0 表示不回放）。
+func NewHub(store history.Store, replayCount int) *Hub {
+	lobby := newRoom(LobbyName)
+	return &Hub{
+		rooms:       map[string]*Room{LobbyName: lobby},
+		index:       make(map[string]*Client),
+		Lobby:       lobby,
+		store:       store,
+		replayCount: replayCount,
+	}
+}
+
+// GetOrCreateRoom 返回指定名字的房间，不存在则创建
+func (h *Hub) GetOrCreateRoom(name string) *Room {
+	h.roomsMu.RLock()
+	room, ok := h.rooms[name]
+	h.roomsMu.RUnlock()
+	if ok {
+		return room
+	}
+
+	h.roomsMu.Lock()
+	defer h.roomsMu.Unlock()
+	if room, ok := h.rooms[name]; ok {
+		return room
+	}
+	room = newRoom(name)
+	h.rooms[name] = room
+	return room
+}
+
+// ListRooms 返回 "房间名(人数)" 的列表，逗号分隔
+func (h *Hub) ListRooms() string {
+	h.roomsMu.RLock()
+	defer h.roomsMu.RUnlock()
+	var parts []string
+	for name, room := range h.rooms {
+		parts = append(parts, fmt.Sprintf("%s(%d)", name, room.MemberCount()))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Register 把新客户端加入用户名索引，名字重复则返回 error。
+// 名字是跨传输层共享的，一个 TCP 用户和一个浏览器用户不能同名。
+func (h *Hub) Register(name string, c *Client) error {
+	h.indexMu.Lock()
+	defer h.indexMu.Unlock()
+	if _, exists := h.index[name]; exists {
+		return fmt.Errorf("用户名 %q 已被占用", name)
+	}
+	h.index[name] = c
+	return nil
+}
+
+// Unregister 从用户名索引中移除客户端
+func (h *Hub) Unregister(name string) {
+	h.indexMu.Lock()
+	defer h.indexMu.Unlock()
+	delete(h.index, name)
+}
+
+// Rename 把客户端从旧名字改名为新名字，名字重复则返回 error
+func (h *Hub) Rename(oldName, newName string, c *Client) error {
+	h.indexMu.Lock()
+	defer h.indexMu.Unlock()
+	if _, exists := h.index[newName]; exists {
+		return fmt.Errorf("用户名 %q 已被占用", newName)
+	}
+	delete(h.index, oldName)
+	h.index[newName] = c
+	return nil
+}
+
+// Lookup 按名字查找在线客户端
+func (h *Hub) Lookup(name string) (*Client, bool) {
+	h.indexMu.RLock()
+	defer h.indexMu.RUnlock()
+	client, ok := h.index[name]
+	return client, ok
+}
+
+// BroadcastAll 向所有在线客户端发送消息，不分房间（用于关服公告）
+func (h *Hub) BroadcastAll(message string) {
+	h.indexMu.RLock()
+	defer h.indexMu.RUnlock()
+	for _, c := range h.index {
+		c.SendMessage(message)
+	}
+}
+
+// CloseAllConns 强制关闭所有在线客户端的底层连接，用于优雅关闭超时后兜底。
+// 连接被关闭后，各自读循环里阻塞的读操作会返回错误，走正常的清理路径。
+func (h *Hub) CloseAllConns() {
+	h.indexMu.RLock()
+	defer h.indexMu.RUnlock()
+	for _, c := range h.index {
+		c.ForceClose()
+	}
+}
+
+// Welcome 把新客户端加入大厅并通知大厅里的其他人，同时回放大厅最近的历史消息。
+func (h *Hub) Welcome(c *Client) {
+	c.roomMu.Lock()
+	c.currentRoom = h.Lobby
+	c.roomMu.Unlock()
+	h.replayHistory(c, h.Lobby.Name)
+	h.Lobby.Join(c)
+	h.Lobby.Broadcast(fmt.Sprintf("系统消息：%s 加入了聊天室\n", c.Name()), c)
+}
+
+// replayHistory 把 room 最近的 replayCount 条历史消息发给 c，在它真正加入房间、
+// 开始接收实时广播之前调用，这样客户端看到的是“历史接着实时”，不会重复也不会断档。
+func (h *Hub) replayHistory(c *Client, room string) {
+	if h.store == nil || h.replayCount <= 0 {
+		return
+	}
+	envs, err := h.store.Recent(context.Background(), room, h.replayCount)
+	if err != nil {
+		return
+	}
+	for _, env := range envs {
+		c.SendReplay(env)
+	}
+}
+
+// Leave 把客户端从当前房间和用户名索引中移除，并广播普通的离开消息。
+// 在客户端连接正常关闭（对端断开、/quit）时调用（defer）。
+func (h *Hub) Leave(c *Client) {
+	h.LeaveWithReason(c, "离开了聊天室")
+}
+
+// LeaveWithReason 和 Leave 一样，但可以指定广播的离开原因，比如读超时
+// 断开的连接想让房间里的其他人看到"因超时断开"而不是普通的"离开了聊天室"，
+// 这样旁观者能分清是对方主动退出还是被服务器当成半开连接踢掉的。
+func (h *Hub) LeaveWithReason(c *Client, reason string) {
+	h.Unregister(c.Name())
+	if room := c.CurrentRoom(); room != nil {
+		room.Leave(c)
+		room.Broadcast(fmt.Sprintf("系统消息：%s %s\n", c.Name(), reason), nil)
+	}
+}
+
+// HandleLine 处理客户端发来的一行文本：以 "/" 开头的是命令，否则广播到当前房间。
+// 返回 false 表示连接应当结束（/quit）。
+func (h *Hub) HandleLine(c *Client, line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return true
+	}
+
+	if strings.HasPrefix(line, "/") {
+		return h.handleCommand(c, line)
+	}
+
+	room := c.CurrentRoom()
+	text := fmt.Sprintf("[%s] %s: %s\n", room.Name, c.Name(), line)
+	h.record(room.Name, c.Name(), line)
+	room.Broadcast(text, c)
+	return true
+}
+
+// record 把一条房间消息存进历史，供之后的 /search 和加入房间时的回放使用。
+// store 为 nil（或者没配置 history）时什么都不做。
+func (h *Hub) record(room, from, text string) {
+	if h.store == nil {
+		return
+	}
+	env := protocol.NewTextEnvelope("message", from, room, text, time.Now().Unix())
+	h.store.Append(context.Background(), env)
+}
+
+func (h *Hub) handleCommand(c *Client, line string) bool {
+	fields := strings.Fields(line)
+	cmd := fields[0]
+	args := fields[1:]
+
+	switch cmd {
+	case "/list":
+		c.SendMessage(fmt.Sprintf("房间列表：%s\n", h.ListRooms()))
+
+	case "/who":
+		room := c.CurrentRoom()
+		c.SendMessage(fmt.Sprintf("【%s】成员：%s\n", room.Name, room.Who()))
+
+	case "/join":
+		if len(args) != 1 {
+			c.SendMessage("错误：用法 /join <room>\n")
+			return true
+		}
+		h.join(c, args[0])
+
+	case "/to":
+		if len(args) < 2 {
+			c.SendMessage("错误：用法 /to <user> <msg>\n")
+			return true
+		}
+		target, ok := h.Lookup(args[0])
+		if !ok {
+			c.SendMessage(fmt.Sprintf("错误：用户 %q 不在线\n", args[0]))
+			return true
+		}
+		msg := strings.Join(args[1:], " ")
+		target.SendMessage(fmt.Sprintf("[私聊] %s: %s\n", c.Name(), msg))
+		c.SendMessage(fmt.Sprintf("[私聊->%s] %s\n", target.Name(), msg))
+
+	case "/nick":
+		if len(args) != 1 {
+			c.SendMessage("错误：用法 /nick <new-name>\n")
+			return true
+		}
+		oldName := c.Name()
+		if err := h.Rename(oldName, args[0], c); err != nil {
+			c.SendMessage(fmt.Sprintf("错误：%v\n", err))
+			return true
+		}
+		c.setName(args[0])
+		c.SendMessage(fmt.Sprintf("昵称已修改为：%s\n", c.Name()))
+
+	case "/search":
+		if len(args) < 1 {
+			c.SendMessage("错误：用法 /search <关键词> [条数，默认 20]\n")
+			return true
+		}
+		h.search(c, args)
+
+	case "/quit":
+		c.SendMessage("再见！\n")
+		return false
+
+	default:
+		c.SendMessage(fmt.Sprintf("错误：未知命令 %q\n", cmd))
+	}
+
+	return true
+}
+
+// search 处理 /search 命令：在客户端当前房间的历史消息里查找关键词。
+// 参数里最后一项如果是数字，就当作返回条数上限，默认 20。
+func (h *Hub) search(c *Client, args []string) {
+	if h.store == nil {
+		c.SendMessage("错误：当前没有开启历史消息存储\n")
+		return
+	}
+
+	n := 20
+	if len(args) > 1 {
+		if parsed, err := strconv.Atoi(args[len(args)-1]); err == nil {
+			n = parsed
+			args = args[:len(args)-1]
+		}
+	}
+	keyword := strings.Join(args, " ")
+
+	room := c.CurrentRoom()
+	results, err := h.store.Search(context.Background(), room.Name, keyword, n)
+	if err != nil {
+		c.SendMessage(fmt.Sprintf("错误：搜索失败：%v\n", err))
+		return
+	}
+	if len(results) == 0 {
+		c.SendMessage(fmt.Sprintf("没有找到包含 %q 的历史消息\n", keyword))
+		return
+	}
+	c.SendMessage(fmt.Sprintf("找到 %d 条包含 %q 的历史消息：\n", len(results), keyword))
+	for _, env := range results {
+		c.SendMessage(env.Text())
+	}
+}
+
+// join 把客户端从当前房间切换到指定房间（不存在则创建）
+func (h *Hub) join(c *Client, roomName string) {
+	target := h.GetOrCreateRoom(roomName)
+
+	c.roomMu.Lock()
+	oldRoom := c.currentRoom
+	if oldRoom == target {
+		c.roomMu.Unlock()
+		c.SendMessage(fmt.Sprintf("你已经在房间 %s 中\n", roomName))
+		return
+	}
+	c.currentRoom = target
+	c.roomMu.Unlock()
+
+	if oldRoom != nil {
+		oldRoom.Leave(c)
+		oldRoom.Broadcast(fmt.Sprintf("系统消息：%s 离开了房间\n", c.Name()), nil)
+	}
+	h.replayHistory(c, target.Name)
+	target.Join(c)
+	target.Broadcast(fmt.Sprintf("系统消息：%s 加入了房间\n", c.Name()), c)
+	c.SendMessage(fmt.Sprintf("已加入房间：%s\n", roomName))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Kirby980/websocket-demo/chat"
+	"github.com/Kirby980/websocket-demo/history"
+	"github.com/Kirby980/websocket-demo/tcpserver"
+	"github.com/Kirby980/websocket-demo/wsgateway"
+)
+
+/*
+【示例3：WebSocket 网关 —— 让浏览器和 TCP 客户端挤在同一个聊天室】
+
+02-tcp-chat-server.go / 02-tcp-chat-client.go 结尾都留了一句话：
+“下一步学习：WebSocket”。这个 cmd 就是那个下一步：它在同一个进程里
+同时跑一个 TCP 监听器和一个 HTTP/WebSocket 监听器，两边共用同一个
+chat.Hub，所以 telnet、nc、Go 版聊天客户端和浏览器标签页说的是同一间
+聊天室，互相能看到彼此的消息。
+
+运行方式：
+  go run ./cmd/ws-gateway
+  浏览器打开 http://localhost:8080 即可聊天
+  或者 telnet/nc localhost 9999 用老办法接入（需要加 --legacy，见下）
+
+TCP 入口默认使用 protocol 包的长度前缀 JSON 帧，这意味着原始 telnet/nc
+再也读不出人类可读的文本了。加上 --legacy 可以切回按行文本协议，
+代价是不能再传二进制或带换行符的消息——这只是迁移期的兼容开关。
+
+--history 选择历史消息存储的后端（memory|file|sqlite），配合 --replay
+决定新客户端进房间时回放多少条历史、--history-path 决定 file/sqlite 的
+落盘位置。默认 memory，进程重启历史就没了，想要持久化换成 file 或 sqlite。
+
+TCP 入口的心跳、读超时、优雅关闭和独立的 02-tcp-chat-server.go 是同一份
+实现（tcpserver 包），--ping-interval/--read-timeout/--shutdown-grace/
+--max-clients 这几个参数的含义和默认值也完全一致。
+*/
+
+func main() {
+	httpAddr := flag.String("http", ":8080", "HTTP/WebSocket 监听地址")
+	tcpAddr := flag.String("tcp", ":9999", "TCP 监听地址")
+	legacy := flag.Bool("legacy", false, "TCP 入口使用旧版按行文本协议，兼容 telnet/nc")
+	pingInterval := flag.Duration("ping-interval", 30*time.Second, "TCP 入口的心跳间隔")
+	readTimeout := flag.Duration("read-timeout", 90*time.Second, "TCP 入口的读超时，超过这个时间没收到数据就判定连接已断开")
+	shutdownGrace := flag.Duration("shutdown-grace", 5*time.Second, "优雅关闭时等待 TCP 客户端自然退出的时间")
+	maxClients := flag.Int("max-clients", 1000, "TCP 入口最大同时在线人数")
+	historyBackend := flag.String("history", "memory", "历史消息存储后端：memory|file|sqlite")
+	historyPath := flag.String("history-path", "history-data", "file/sqlite 后端的落盘位置（file 是目录，sqlite 是数据库文件）")
+	historySync := flag.Bool("history-sync", true, "file 后端是否每次写历史都 fsync；关掉可以提高吞吐，代价是崩溃可能丢最后几条历史")
+	replayCount := flag.Int("replay", 20, "客户端加入房间时回放的历史消息条数，<=0 表示不回放")
+	flag.Parse()
+
+	store, err := newHistoryStore(*historyBackend, *historyPath, *historySync)
+	if err != nil {
+		log.Fatalf("初始化历史存储失败: %v", err)
+	}
+
+	hub := chat.NewHub(store, *replayCount)
+
+	listener, err := net.Listen("tcp", *tcpAddr)
+	if err != nil {
+		log.Fatalf("TCP 监听失败: %v", err)
+	}
+	tcpSrv := tcpserver.New(listener, hub, tcpserver.Config{
+		Legacy:        *legacy,
+		PingInterval:  *pingInterval,
+		ReadTimeout:   *readTimeout,
+		ShutdownGrace: *shutdownGrace,
+		MaxClients:    int32(*maxClients),
+	})
+	fmt.Printf("TCP 聊天入口启动成功！监听地址: %s（legacy=%v）\n", *tcpAddr, *legacy)
+	go tcpSrv.Serve()
+
+	// Ctrl+C/SIGTERM 时优雅关闭 TCP 入口，和独立的 02-tcp-chat-server.go 行为一致
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		fmt.Println("\n收到退出信号，开始优雅关闭 TCP 入口...")
+		tcpSrv.Shutdown()
+		os.Exit(0)
+	}()
+
+	gw := wsgateway.New(hub)
+	fmt.Printf("WebSocket 网关启动成功！浏览器访问 http://localhost%s\n", *httpAddr)
+	if err := http.ListenAndServe(*httpAddr, gw.Handler()); err != nil {
+		log.Fatalf("HTTP 服务器启动失败: %v", err)
+	}
+}
+
+// newHistoryStore 按 --history 的值构造对应的历史存储后端。
+func newHistoryStore(backend, path string, sync bool) (history.Store, error) {
+	switch backend {
+	case "memory":
+		return history.NewMemoryStore(200), nil
+	case "file":
+		return history.NewFileStoreWithSync(path, sync)
+	case "sqlite":
+		return history.NewSQLiteStore(path)
+	default:
+		return nil, fmt.Errorf("未知的历史存储后端 %q（可选 memory|file|sqlite）", backend)
+	}
+}
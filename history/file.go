@@ -0,0 +1,171 @@
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Kirby980/websocket-demo/protocol"
+)
+
+// FileStore 把每个房间的历史消息追加写到 dir 下的一个 JSON Lines 文件里
+// （<dir>/<room>.jsonl，每行一个 Envelope 的 JSON）。比 MemoryStore 多一次
+// 磁盘 IO，但进程重启、甚至换一台机器重新跑这个目录都不会丢历史。
+type FileStore struct {
+	dir       string
+	syncEvery bool // 每次 Append 后是否 f.Sync()，见 NewFileStore 的说明
+
+	mu     sync.Mutex             // 保护 files map 本身的读写
+	files  map[string]*os.File    // room -> 追加写打开的文件句柄
+	fileMu map[string]*sync.Mutex // room -> 该文件的写锁，避免并发 Append 交错写坏一行
+}
+
+// NewFileStore 创建一个以 dir 为根目录的历史存储，dir 不存在会被自动创建。
+// 每条消息落盘后都会 fsync，牺牲一些吞吐换崩溃时不丢最后几条历史；
+// 想要更高的写入吞吐、能接受断电丢最近几条消息，用 NewFileStoreWithSync(dir, false)。
+func NewFileStore(dir string) (*FileStore, error) {
+	return NewFileStoreWithSync(dir, true)
+}
+
+// NewFileStoreWithSync 和 NewFileStore 一样，但可以显式关掉每次 Append 的
+// fsync：syncEvery=false 时只 Write 不 Sync，数据留在操作系统的页缓存里，
+// 由内核自行回写到磁盘，吞吐更高，代价是断电或操作系统崩溃可能丢最后
+// 几条还没刷盘的历史消息（单纯的进程崩溃不会丢，Write 已经交给了内核）。
+func NewFileStoreWithSync(dir string, syncEvery bool) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("history: create dir %q: %w", dir, err)
+	}
+	return &FileStore{
+		dir:       dir,
+		syncEvery: syncEvery,
+		files:     make(map[string]*os.File),
+		fileMu:    make(map[string]*sync.Mutex),
+	}, nil
+}
+
+func (s *FileStore) roomPath(room string) string {
+	// 房间名里可能有 "/" 之类的字符，这里只做最基本的清洗，演示用途足够。
+	safe := strings.NewReplacer("/", "_", "\\", "_").Replace(room)
+	return filepath.Join(s.dir, safe+".jsonl")
+}
+
+func (s *FileStore) lockFor(room string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.fileMu[room]
+	if !ok {
+		m = &sync.Mutex{}
+		s.fileMu[room] = m
+	}
+	return m
+}
+
+func (s *FileStore) fileFor(room string) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.files[room]; ok {
+		return f, nil
+	}
+	f, err := os.OpenFile(s.roomPath(room), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s.files[room] = f
+	return f, nil
+}
+
+// Append 把 env 序列化成一行 JSON 追加写到 env.Room 对应的文件末尾。
+func (s *FileStore) Append(ctx context.Context, env *protocol.Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return fmt.Errorf("history: encode envelope: %w", err)
+	}
+
+	f, err := s.fileFor(env.Room)
+	if err != nil {
+		return err
+	}
+
+	lock := s.lockFor(env.Room)
+	lock.Lock()
+	defer lock.Unlock()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if !s.syncEvery {
+		return nil
+	}
+	return f.Sync()
+}
+
+// readAll 按顺序读出 room 文件里的全部消息，文件不存在视为空历史。
+func (s *FileStore) readAll(room string) ([]*protocol.Envelope, error) {
+	lock := s.lockFor(room)
+	lock.Lock()
+	defer lock.Unlock()
+
+	f, err := os.Open(s.roomPath(room))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []*protocol.Envelope
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var env protocol.Envelope
+		if err := json.Unmarshal(line, &env); err != nil {
+			continue // 跳过损坏的行，不让一条坏数据拖垮整段历史
+		}
+		out = append(out, &env)
+	}
+	return out, scanner.Err()
+}
+
+// Recent 返回 room 最近的最多 n 条消息，按时间从旧到新排序。
+func (s *FileStore) Recent(ctx context.Context, room string, n int) ([]*protocol.Envelope, error) {
+	all, err := s.readAll(room)
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 || n > len(all) {
+		n = len(all)
+	}
+	return all[len(all)-n:], nil
+}
+
+// Search 在 room 的历史消息里查找正文包含 substr 的消息，取最新的最多 n 条，
+// 按时间从旧到新排序返回（和 SQLiteStore.Search 的"最新 n 条"语义保持一致，
+// 而不是从旧到新数到 n 条就截断——同样的内容，换个 --history 后端不该选出
+// 不一样的那几条）。
+func (s *FileStore) Search(ctx context.Context, room, substr string, n int) ([]*protocol.Envelope, error) {
+	all, err := s.readAll(room)
+	if err != nil {
+		return nil, err
+	}
+	var out []*protocol.Envelope
+	for i := len(all) - 1; i >= 0; i-- {
+		env := all[i]
+		if strings.Contains(env.Text(), substr) {
+			out = append(out, env)
+			if n > 0 && len(out) >= n {
+				break
+			}
+		}
+	}
+	reverse(out)
+	return out, nil
+}
@@ -0,0 +1,20 @@
+// Package history 定义聊天室的消息历史存储接口，以及几种可插拔的实现：
+// 内存环形缓冲区、按房间落盘的 JSON Lines 文件，和 SQLite。
+//
+// 聊天室默认用内存实现，这样 demo 不需要任何外部依赖就能跑起来；
+// 想要重启不丢历史、或者跨进程搜索历史消息，可以换成 file 或 sqlite。
+package history
+
+import (
+	"context"
+
+	"github.com/Kirby980/websocket-demo/protocol"
+)
+
+// Store 是历史消息存储的统一接口。Append 按 env.Room 归档一条消息；
+// Recent/Search 都返回按时间从旧到新排序的结果，最多 n 条。
+type Store interface {
+	Append(ctx context.Context, env *protocol.Envelope) error
+	Recent(ctx context.Context, room string, n int) ([]*protocol.Envelope, error)
+	Search(ctx context.Context, room, substr string, n int) ([]*protocol.Envelope, error)
+}
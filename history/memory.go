@@ -0,0 +1,122 @@
+package history
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/Kirby980/websocket-demo/protocol"
+)
+
+// MemoryStore 把每个房间的历史消息存在一个定长环形缓冲区里，进程重启后丢失。
+// 这是默认实现：不需要任何外部依赖，适合演示和短期会话；
+// 想要重启不丢历史，换成 FileStore 或 SQLiteStore。
+type MemoryStore struct {
+	capacity int
+
+	mu    sync.Mutex
+	rooms map[string]*ringBuffer // 每个房间一个环形缓冲区，按到达顺序覆盖
+}
+
+// ringBuffer 是一个定长的环形缓冲区：buf 的长度固定为 capacity，head 指向
+// 最旧的一条，count 是当前有效条数（<= capacity）。Append 满了之后只是
+// 覆盖 head 指向的格子再把 head 前移一格，不会触发任何内存搬迁或重新分配，
+// 是真正的 O(1) 追加，而不是 append+重新切片那种需要不断拷贝旧数据的写法。
+type ringBuffer struct {
+	buf   []*protocol.Envelope
+	head  int
+	count int
+}
+
+func newRingBuffer(capacity int) *ringBuffer {
+	return &ringBuffer{buf: make([]*protocol.Envelope, capacity)}
+}
+
+func (r *ringBuffer) push(env *protocol.Envelope) {
+	capacity := len(r.buf)
+	if r.count < capacity {
+		r.buf[(r.head+r.count)%capacity] = env
+		r.count++
+		return
+	}
+	r.buf[r.head] = env
+	r.head = (r.head + 1) % capacity
+}
+
+// ordered 按到达顺序（从旧到新）返回缓冲区里当前的所有消息。
+func (r *ringBuffer) ordered() []*protocol.Envelope {
+	capacity := len(r.buf)
+	out := make([]*protocol.Envelope, r.count)
+	for i := 0; i < r.count; i++ {
+		out[i] = r.buf[(r.head+i)%capacity]
+	}
+	return out
+}
+
+// NewMemoryStore 创建一个内存历史存储，每个房间最多保留 capacity 条消息。
+func NewMemoryStore(capacity int) *MemoryStore {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &MemoryStore{
+		capacity: capacity,
+		rooms:    make(map[string]*ringBuffer),
+	}
+}
+
+// Append 把 env 追加到 env.Room 的环形缓冲区，超过容量则覆盖最旧的一条。
+func (s *MemoryStore) Append(ctx context.Context, env *protocol.Envelope) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rb, ok := s.rooms[env.Room]
+	if !ok {
+		rb = newRingBuffer(s.capacity)
+		s.rooms[env.Room] = rb
+	}
+	rb.push(env)
+	return nil
+}
+
+// Recent 返回 room 最近的最多 n 条消息，按时间从旧到新排序。
+func (s *MemoryStore) Recent(ctx context.Context, room string, n int) ([]*protocol.Envelope, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rb, ok := s.rooms[room]
+	if !ok {
+		return nil, nil
+	}
+	buf := rb.ordered()
+	if n <= 0 || n > len(buf) {
+		n = len(buf)
+	}
+	return buf[len(buf)-n:], nil
+}
+
+// Search 在 room 的历史消息里查找正文包含 substr 的消息，取最新的最多 n 条，
+// 按时间从旧到新排序返回（和 SQLiteStore.Search 的"最新 n 条"语义保持一致，
+// 而不是从旧到新数到 n 条就截断——同样的内容，换个 --history 后端不该选出
+// 不一样的那几条）。
+func (s *MemoryStore) Search(ctx context.Context, room, substr string, n int) ([]*protocol.Envelope, error) {
+	s.mu.Lock()
+	rb, ok := s.rooms[room]
+	var buf []*protocol.Envelope
+	if ok {
+		buf = rb.ordered()
+	}
+	s.mu.Unlock()
+
+	var out []*protocol.Envelope
+	for i := len(buf) - 1; i >= 0; i-- {
+		env := buf[i]
+		if strings.Contains(env.Text(), substr) {
+			out = append(out, env)
+			if n > 0 && len(out) >= n {
+				break
+			}
+		}
+	}
+	reverse(out)
+	return out, nil
+}
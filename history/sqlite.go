@@ -0,0 +1,154 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/Kirby980/websocket-demo/protocol"
+	_ "modernc.org/sqlite" // 纯 Go 实现，不需要 cgo，注册 "sqlite" 驱动
+)
+
+// SQLiteStore 把历史消息存进一张 SQLite 表，(room, timestamp) 上建了索引，
+// 既能按房间翻最近消息，也能做 LIKE 检索。比 FileStore 多了真正的随机访问能力，
+// 适合历史量大、需要频繁 /search 的部署。
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore 打开（或创建）path 处的 SQLite 数据库文件，并确保表结构存在。
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	// LIKE 默认只对 ASCII 大小写不敏感，MemoryStore/FileStore 的
+	// strings.Contains 则是大小写敏感的。database/sql 在一个连接池上跑
+	// 查询，`PRAGMA case_sensitive_like` 只对执行它的那一个连接生效——
+	// 如果用 db.Exec 打开后单独设一次，池子里新开的连接不会继承，高并发下
+	// /search 会在"大小写敏感"和"不敏感"之间随机跳。把它写进 DSN，让
+	// database/sql 新建的每一个连接在打开时都带上这个 pragma，才是真正
+	// 连接无关地生效。
+	db, err := sql.Open("sqlite", path+"?_pragma=case_sensitive_like(1)")
+	if err != nil {
+		return nil, fmt.Errorf("history: open sqlite %q: %w", path, err)
+	}
+
+	const schema = `
+CREATE TABLE IF NOT EXISTS messages (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	room      TEXT NOT NULL,
+	type      TEXT NOT NULL,
+	from_name TEXT,
+	to_name   TEXT,
+	body      TEXT,
+	text      TEXT,
+	timestamp INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_messages_room_ts ON messages(room, timestamp);
+`
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("history: create schema: %w", err)
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// escapeLikePattern 把 substr 变成一个只匹配字面量的 LIKE 模式：先转义
+// substr 里本来就有的 %、_、\，再套上首尾的 % 通配符做"包含"匹配。不转义
+// 的话，用户搜索里的 % 和 _ 会被当成 LIKE 通配符，搜索行为就不再是字面量
+// 子串匹配了（和 strings.Contains 的语义对不上）。
+func escapeLikePattern(substr string) string {
+	escaped := strings.NewReplacer(
+		`\`, `\\`,
+		"%", `\%`,
+		"_", `\_`,
+	).Replace(substr)
+	return "%" + escaped + "%"
+}
+
+// Close 关闭底层数据库连接。
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// Append 把 env 插入 messages 表；text 列额外保存解出来的纯文本，方便 Search 直接 LIKE。
+func (s *SQLiteStore) Append(ctx context.Context, env *protocol.Envelope) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO messages(room, type, from_name, to_name, body, text, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		env.Room, env.Type, env.From, env.To, string(env.Body), env.Text(), env.Timestamp,
+	)
+	return err
+}
+
+// Recent 返回 room 最近的最多 n 条消息，按时间从旧到新排序。
+func (s *SQLiteStore) Recent(ctx context.Context, room string, n int) ([]*protocol.Envelope, error) {
+	if n <= 0 {
+		n = 50
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT type, from_name, to_name, body, timestamp FROM messages
+		 WHERE room = ? ORDER BY timestamp DESC, id DESC LIMIT ?`,
+		room, n,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	envs, err := scanEnvelopes(rows, room)
+	if err != nil {
+		return nil, err
+	}
+	reverse(envs)
+	return envs, nil
+}
+
+// Search 在 room 的历史消息里查找正文包含 substr 的消息，按时间从旧到新排序，最多 n 条。
+func (s *SQLiteStore) Search(ctx context.Context, room, substr string, n int) ([]*protocol.Envelope, error) {
+	if n <= 0 {
+		n = 50
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT type, from_name, to_name, body, timestamp FROM messages
+		 WHERE room = ? AND text LIKE ? ESCAPE '\' ORDER BY timestamp DESC, id DESC LIMIT ?`,
+		room, escapeLikePattern(substr), n,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	envs, err := scanEnvelopes(rows, room)
+	if err != nil {
+		return nil, err
+	}
+	reverse(envs)
+	return envs, nil
+}
+
+func scanEnvelopes(rows *sql.Rows, room string) ([]*protocol.Envelope, error) {
+	var out []*protocol.Envelope
+	for rows.Next() {
+		var (
+			typ, from, to, body string
+			ts                  int64
+		)
+		if err := rows.Scan(&typ, &from, &to, &body, &ts); err != nil {
+			return nil, err
+		}
+		out = append(out, &protocol.Envelope{
+			Type:      typ,
+			From:      from,
+			To:        to,
+			Room:      room,
+			Body:      []byte(body),
+			Timestamp: ts,
+		})
+	}
+	return out, rows.Err()
+}
+
+func reverse(envs []*protocol.Envelope) {
+	for i, j := 0, len(envs)-1; i < j; i, j = i+1, j-1 {
+		envs[i], envs[j] = envs[j], envs[i]
+	}
+}
@@ -0,0 +1,106 @@
+// Package protocol 定义聊天服务使用的线上帧格式：一个 uint32 大端长度
+// 前缀，后面跟着那么多字节的 JSON（一个 Envelope）。
+//
+// 之前所有示例都用 bufio.Reader.ReadString('\n') 按行读取，这在消息本身
+// 包含换行符、是二进制数据，或者单行长度超过 bufio 默认缓冲区时都会出问题
+// （02-tcp-chat-server.go 早先留着的一行注释掉的 NewReaderSize(conn, 5)
+// 就是作者当年踩到这个坑留下的痕迹）。长度前缀帧可以安全地承载任意字节的 Body。
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxFrameSize 是 ReadEnvelope 在调用方没有指定时使用的默认上限，
+// 防止一个声称几个 GB 大小的帧把服务器内存耗尽。
+const DefaultMaxFrameSize = 1 << 20 // 1 MiB
+
+// Envelope 是在聊天服务里流转的统一消息格式。
+type Envelope struct {
+	Type      string          `json:"type"`
+	From      string          `json:"from,omitempty"`
+	To        string          `json:"to,omitempty"`
+	Room      string          `json:"room,omitempty"`
+	Body      json.RawMessage `json:"body,omitempty"`
+	Timestamp int64           `json:"timestamp"`
+}
+
+// textBody 是最常见的 Body 形状：一段纯文本。
+type textBody struct {
+	Text string `json:"text"`
+}
+
+// NewTextEnvelope 构造一个 Body 为 {"text": text} 的 Envelope。
+func NewTextEnvelope(typ, from, room, text string, timestamp int64) *Envelope {
+	body, _ := json.Marshal(textBody{Text: text})
+	return &Envelope{
+		Type:      typ,
+		From:      from,
+		Room:      room,
+		Body:      body,
+		Timestamp: timestamp,
+	}
+}
+
+// Text 把 Body 当作 textBody 解出其中的文本；解不出来就返回空字符串。
+func (e *Envelope) Text() string {
+	if e == nil {
+		return ""
+	}
+	var b textBody
+	if err := json.Unmarshal(e.Body, &b); err != nil {
+		return ""
+	}
+	return b.Text
+}
+
+// ReadEnvelope 从 r 读取一帧：4 字节大端长度 + 那么多字节的 JSON。
+// maxFrameSize 为 0 时使用 DefaultMaxFrameSize。超过上限的帧会被拒绝而不是
+// 读入内存，避免恶意或损坏的长度前缀导致 OOM。
+func ReadEnvelope(r io.Reader, maxFrameSize uint32) (*Envelope, error) {
+	if maxFrameSize == 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(lenBuf[:])
+	if size == 0 {
+		return nil, fmt.Errorf("protocol: empty frame")
+	}
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("protocol: frame size %d exceeds max %d", size, maxFrameSize)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	var env Envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, fmt.Errorf("protocol: decode envelope: %w", err)
+	}
+	return &env, nil
+}
+
+// WriteEnvelope 把 e 编码为 JSON，并以 4 字节大端长度前缀写入 w。
+func WriteEnvelope(w io.Writer, e *Envelope) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("protocol: encode envelope: %w", err)
+	}
+
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(data)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
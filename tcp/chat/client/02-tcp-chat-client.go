@@ -2,11 +2,15 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"net"
 	"os"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/Kirby980/websocket-demo/protocol"
 )
 
 /*
@@ -22,13 +26,16 @@ import (
 - 示例2：异步模式，可以随时收发消息（类似真实的聊天应用）
 
 运行方式：
-  1. 先运行服务器：go run 02-tcp-chat-server.go
-  2. 运行多个客户端：go run 02-tcp-chat-client.go
+  1. 先运行服务器：go run 02-tcp-chat-server.go [--legacy]
+  2. 运行多个客户端：go run 02-tcp-chat-client.go [--legacy]（要和服务器的协议模式一致）
   3. 在不同客户端输入消息，观察群聊效果
   4. 输入 "quit" 退出
 */
 
 func main() {
+	legacy := flag.Bool("legacy", false, "使用旧版按行文本协议，需要和服务器的 --legacy 保持一致")
+	flag.Parse()
+
 	// 第一步：连接到聊天服务器
 	conn, err := net.Dial("tcp", "localhost:9999")
 	if err != nil {
@@ -49,16 +56,21 @@ func main() {
 		fmt.Printf("读取名字失败: %v\n", err)
 		return
 	}
+	name = strings.TrimSpace(name)
+
+	// 收发消息的 goroutine 和收到心跳后回 pong 的 goroutine 都会往 conn 上写，
+	// 一个锁保证两边不会交错写坏一帧
+	var writeMu sync.Mutex
 
 	// 发送名字到服务器
-	_, err = conn.Write([]byte(name))
-	if err != nil {
+	if err := sendLine(conn, name, *legacy, &writeMu); err != nil {
 		fmt.Printf("发送名字失败: %v\n", err)
 		return
 	}
 
 	fmt.Println("========================================")
 	fmt.Println("进入聊天室！输入消息后回车发送，输入 'quit' 退出")
+	fmt.Println("支持的命令：/list /who /join <room> /to <user> <msg> /nick <name> /search <kw> [n] /quit")
 	fmt.Println("========================================")
 
 	// 【关键点1】创建一个 channel 用于通知程序退出
@@ -71,7 +83,7 @@ func main() {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		receiveMessages(conn, done)
+		receiveMessages(conn, done, *legacy, &writeMu)
 	}()
 
 	// 【关键点3】主 goroutine 处理用户输入
@@ -101,8 +113,7 @@ func main() {
 		}
 
 		// 发送消息到服务器
-		_, err = conn.Write([]byte(message + "\n"))
-		if err != nil {
+		if err := sendLine(conn, message, *legacy, &writeMu); err != nil {
 			fmt.Printf("\n发送消息失败: %v\n", err)
 			break
 		}
@@ -110,9 +121,32 @@ func main() {
 	wg.Wait()
 }
 
+// sendLine 按协议模式把一行文本发给服务器：legacy 模式直接写 "<line>\n"，
+// 否则包成一个 protocol.Envelope，以长度前缀帧写出去。writeMu 保证和
+// receiveMessages 里回 pong 的写操作不会交错。
+func sendLine(conn net.Conn, line string, legacy bool, writeMu *sync.Mutex) error {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	if legacy {
+		_, err := conn.Write([]byte(line + "\n"))
+		return err
+	}
+	env := protocol.NewTextEnvelope("message", "", "", line, time.Now().Unix())
+	return protocol.WriteEnvelope(conn, env)
+}
+
+// sendPong 回一条 Type 为 "pong" 的信封，证明连接还活着；和聊天消息用
+// 同一把 writeMu，避免和用户主动发送的消息交错写坏一帧。
+func sendPong(conn net.Conn, writeMu *sync.Mutex) error {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	env := &protocol.Envelope{Type: "pong", Timestamp: time.Now().Unix()}
+	return protocol.WriteEnvelope(conn, env)
+}
+
 // receiveMessages 持续接收服务器发来的消息
 // 这个函数在独立的 goroutine 中运行
-func receiveMessages(conn net.Conn, done chan bool) {
+func receiveMessages(conn net.Conn, done chan bool, legacy bool, writeMu *sync.Mutex) {
 	reader := bufio.NewReader(conn)
 
 	for {
@@ -129,7 +163,27 @@ func receiveMessages(conn net.Conn, done chan bool) {
 		// 这样可以定期检查 done channel
 		//conn.SetReadDeadline(time.Now().Add(1 * time.Second))
 		// 从服务器读取消息
-		message, err := reader.ReadString('\n')
+		var message string
+		var err error
+		if legacy {
+			message, err = reader.ReadString('\n')
+		} else {
+			var env *protocol.Envelope
+			env, err = protocol.ReadEnvelope(reader, protocol.DefaultMaxFrameSize)
+			if err == nil {
+				if env.Type == "ping" {
+					// 服务器心跳：不展示给用户看，回一个 pong 证明连接还活着。
+					// 服务器靠 pong 往返续读超时，不回的话即使一直在收消息
+					// 也会在 --read-timeout 后被当成半开连接踢掉
+					if pongErr := sendPong(conn, writeMu); pongErr != nil {
+						fmt.Printf("\n回复心跳失败: %v\n", pongErr)
+						return
+					}
+					continue
+				}
+				message = env.Text()
+			}
+		}
 		if err != nil {
 			// 检查是否是超时错误
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
@@ -222,19 +276,26 @@ A: 可以！打开多个终端，每个运行一个客户端实例
   接收线程：接收 -> 打印 -> 接收 -> 打印 -> ...
   （异步，多线程）
 
-【改进方向】
+【命令支持（已实现，由服务器解析）】
+
+客户端本身不解析命令，只是把以 "/" 开头的行原样发给服务器，
+服务器按 chat 包里的 Hub.handleCommand 处理：
+   /list               列出所有房间及人数
+   /who                列出当前房间成员
+   /join <room>        切换（或创建）房间
+   /to <user> <msg>    私聊指定用户
+   /nick <name>        修改昵称
+   /search <kw> [n]    搜索当前房间的历史消息
+   /quit               断开连接
 
-1. 添加命令支持：
-   /list - 列出在线用户
-   /quit - 退出
-   /to <用户> <消息> - 私聊
+【改进方向】
 
-2. 改进显示：
+1. 改进显示：
    - 使用终端控制库（如 termbox-go）
    - 分离输入区和消息区
    - 添加颜色和格式
 
-3. 添加功能：
+2. 添加功能：
    - 消息历史记录
    - 表情支持
    - 文件传输
@@ -1,14 +1,17 @@
 package main
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
 	"net"
 	"os"
-	"strings"
-	"sync"
-	"sync/atomic"
+	"os/signal"
+	"syscall"
 	"time"
+
+	"github.com/Kirby980/websocket-demo/chat"
+	"github.com/Kirby980/websocket-demo/history"
+	"github.com/Kirby980/websocket-demo/tcpserver"
 )
 
 /*
@@ -18,10 +21,11 @@ import (
 1. 如何使用 goroutine 并发处理多个客户端
 2. 客户端之间的消息广播（群聊）
 3. 连接管理和资源清理
+4. 多房间（多聊天室）+ 斜杠命令
 
 与示例1的区别：
 - 示例1：同步处理，一次只能服务一个客户端
-- 示例2：并发处理，可以同时服务多个客户端
+- 示例2：并发处理，可以同时服务多个客户端，支持多个房间和私聊
 
 运行方式：
   go run 02-tcp-chat-server.go
@@ -32,230 +36,175 @@ import (
   或
   nc localhost 9999
 
-  在任意一个终端输入消息，所有其他终端都能收到！
+  在任意一个终端输入消息，同一房间的其他终端都能收到！
+  （telnet/nc 只会发纯文本，服务器需要以 --legacy 启动，见下面的“线上协议”）
+
+支持的命令（以 "/" 开头）：
+  /list                列出所有房间及人数
+  /who                 列出当前房间的成员
+  /join <room>         加入（不存在则创建）指定房间
+  /to <user> <msg>     给指定用户发私聊消息
+  /nick <name>         修改昵称
+  /search <关键词> [n] 在当前房间的历史消息里搜索，默认最多返回 20 条
+  /quit                断开连接
+  其余未知命令只会把错误提示返回给发送者，不会广播
+
+历史消息：
+  每条房间里的聊天消息都会记到 --history 指定的存储里（memory|file|sqlite，
+  默认 memory，进程重启即丢）；加入/离开房间这类系统通知不记。客户端每次
+  进入一个房间（大厅或 /join 之后）都会收到该房间最近 --replay 条历史消息，
+  /search 也是查的这份存储。
+
+线上协议：
+  默认使用 protocol 包的帧格式（4 字节大端长度 + JSON Envelope），
+  可以安全地承载任意字节，不再受 bufio.ReadString('\n') 按行读取的限制。
+  telnet/nc 这类只会发纯文本的客户端需要加 --legacy 参数，退回到老的
+  按行文本协议（依然不能带换行符或二进制数据，只是为了平滑迁移保留）。
+
+心跳与优雅关闭：
+  服务器会按 --ping-interval 定期给每个客户端发一条心跳，并在每次成功
+  读取后把读超时往后推 --read-timeout；超过这个时间没收到任何数据就
+  判定连接已经半开（网线拔了、NAT 表项过期……），主动断开。
+  --legacy 模式下不发心跳、也不设读超时，只打开 TCP keepalive
+  （探测周期取 --ping-interval）。
+  Ctrl+C（SIGINT）或 SIGTERM 会触发优雅关闭：停止接受新连接、广播一条
+  "服务器关闭" 消息，并在 --shutdown-grace 时间内等待所有客户端 goroutine
+  自然退出；超时还没退出的连接会被强制关闭。
+
+房间、广播、命令这些逻辑本身不再长在这个文件里——它们现在是 chat 包
+（最初就是从这个文件抽出去的），和 ws-gateway 共用同一份实现。这个文件
+剩下的只是 TCP 这一层传输细节，由 tcpserver 包负责，这样 telnet/nc 接入
+的聊天室和浏览器用 WebSocket 接入的聊天室其实是同一个进程、同一批房间。
 */
 
-// 客户端结构体
-type Client struct {
-	conn    net.Conn      // TCP 连接
-	name    string        // 客户端名称
-	writer  *bufio.Writer // 带缓冲的写入器，提高性能
-	msgChan chan string
-}
-
-// 全局变量：管理所有连接的客户端
-var (
-	// clients 存储所有在线客户端
-	//clients    = make(map[*Client]bool)
-	newClients = sync.Map{}
-
-	// clientsMutex 保护 clients map 的并发访问
-	// 因为多个 goroutine 会同时读写这个 map
-	//clientsMutex sync.Mutex
-
-	// 客户端计数器，用于生成客户端名称
-	clientCounter int32
-)
-
 func main() {
+	legacy := flag.Bool("legacy", false, "使用旧版按行文本协议，兼容 telnet/nc")
+	pingInterval := flag.Duration("ping-interval", 30*time.Second, "心跳间隔")
+	readTimeout := flag.Duration("read-timeout", 90*time.Second, "读超时，超过这个时间没收到数据就判定连接已断开")
+	shutdownGrace := flag.Duration("shutdown-grace", 5*time.Second, "优雅关闭时等待客户端自然退出的时间")
+	maxClients := flag.Int("max-clients", 1000, "最大同时在线人数")
+	historyBackend := flag.String("history", "memory", "历史消息存储后端：memory|file|sqlite")
+	historyPath := flag.String("history-path", "history-data", "file/sqlite 后端的落盘位置（file 是目录，sqlite 是数据库文件）")
+	historySync := flag.Bool("history-sync", true, "file 后端是否每次写历史都 fsync；关掉可以提高吞吐，代价是崩溃可能丢最后几条历史")
+	replayCount := flag.Int("replay", 20, "客户端加入房间时回放的历史消息条数，<=0 表示不回放")
+	flag.Parse()
+
+	store, err := newHistoryStore(*historyBackend, *historyPath, *historySync)
+	if err != nil {
+		fmt.Printf("初始化历史存储失败: %v\n", err)
+		os.Exit(1)
+	}
+	hub := chat.NewHub(store, *replayCount)
+
 	listener, err := net.Listen("tcp", ":9999")
 	if err != nil {
 		fmt.Printf("监听失败: %v\n", err)
 		os.Exit(1)
 	}
-	defer listener.Close()
+
+	server := tcpserver.New(listener, hub, tcpserver.Config{
+		Legacy:        *legacy,
+		PingInterval:  *pingInterval,
+		ReadTimeout:   *readTimeout,
+		ShutdownGrace: *shutdownGrace,
+		MaxClients:    int32(*maxClients),
+	})
 
 	fmt.Println("TCP 聊天服务器启动成功！")
 	fmt.Println("监听地址: localhost:9999")
+	fmt.Printf("协议模式: legacy=%v\n", *legacy)
 	fmt.Println("等待客户端连接...")
 	fmt.Println("========================================")
 
-	// 循环接受客户端连接
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			fmt.Printf("接受连接失败: %v\n", err)
-			continue
-		}
-
-		// 【关键点1】为每个客户端启动一个新的 goroutine
-		// 这样可以同时处理多个客户端，不会互相阻塞
-		go handleClient(conn)
-	}
-}
-
-// handleClient 处理单个客户端的连接
-func handleClient(conn net.Conn) {
-	reader := bufio.NewReader(conn)
-	name, _ := reader.ReadString('\n')
-	atomic.AddInt32(&clientCounter, 1)
-
-	// 创建客户端对象
-	//clientCounter++
-	client := &Client{
-		conn:    conn,
-		name:    strings.TrimSpace(name),
-		writer:  bufio.NewWriter(conn),
-		msgChan: make(chan string, 100),
-	}
-
-	// 【关键点2】注册客户端到全局列表
-	// 需要加锁，因为可能有多个 goroutine 同时修改 clients map
-
-	// clientsMutex.Lock()
-	// clients[client] = true
-	// clientsMutex.Unlock()
-	go client.writePump()
-	// 连接建立时的提示
-	fmt.Printf("[%s] %s 加入聊天室，来自 %s\n",
-		getCurrentTime(), client.name, conn.RemoteAddr())
-
-	// 向客户端发送欢迎消息
-	client.sendMessage(fmt.Sprintf("欢迎来到聊天室！你的名字是：%s\n", client.name))
-	client.sendMessage(fmt.Sprintf("当前在线人数：%d\n", clientCounter))
-	newClients.Store(client, true)
-
-	// 广播：通知所有其他客户端有新人加入
-	broadcast(fmt.Sprintf("系统消息：%s 加入了聊天室\n", client.name), client)
-	broadcast(fmt.Sprintf("当前在线人数：%d\n", clientCounter), client)
-
-	// 【关键点3】确保连接关闭时清理资源
-	defer func() {
-		// 从客户端列表中移除
-		// clientsMutex.Lock()
-		// delete(clients, client)
-		// clientsMutex.Unlock()
-		atomic.AddInt32(&clientCounter, -1)
-		newClients.Delete(client)
-		close(client.msgChan)
-		fmt.Printf("[%s] %s 离开聊天室\n", getCurrentTime(), client.name)
-
-		// 广播：通知所有客户端有人离开
-		broadcast(fmt.Sprintf("系统消息：%s 离开了聊天室\n", client.name), nil)
-		broadcast(fmt.Sprintf("当前在线人数：%d\n", clientCounter), nil)
-
-	}()
-
-	// 循环读取客户端消息
-	reader = bufio.NewReader(conn)
-	//reader := bufio.NewReaderSize(conn, 5)
-	for {
-		message, err := reader.ReadString('\n')
-		if err != nil {
-			// 客户端断开连接
-			return
-		}
-
-		// 去除首尾空白字符
-		message = strings.TrimSpace(message)
-		if message == "" {
-			continue
-		}
-
-		// 打印到服务器控制台
-		fmt.Printf("[%s] %s: %s\n", getCurrentTime(), client.name, message)
-
-		// 【关键点4】广播消息给所有其他客户端
-		broadcast(fmt.Sprintf("%s: %s\n", client.name, message), client)
-	}
-}
-
-func (c *Client) writePump() {
-	defer c.conn.Close()
-	for msg := range c.msgChan {
-		c.writer.WriteString(msg)
-		c.writer.Flush()
-	}
-}
-
-// broadcast 向所有客户端广播消息（可选择排除某个客户端）
-func broadcast(message string, exclude *Client) {
-	newClients.Range(func(key, value any) bool {
-		client := key.(*Client)
-		if client == exclude {
-			return true
-		}
-		select {
-		case client.msgChan <- message:
-		default:
-			fmt.Printf("client %s channel full, skip\n", client.name)
-		}
-		//client.sendMessage(message)
-		return true
-	})
+	go server.Serve()
 
-	// // 遍历所有在线客户端
-	// for client := range newClients {
-	// 	// 排除指定的客户端（通常是消息发送者自己）
-	// 	if client == exclude {
-	// 		continue
-	// 	}
-	// 	// 发送消息
-	// 	client.sendMessage(message)
-	// }
+	// 收到 SIGINT/SIGTERM 时触发优雅关闭，而不是直接被进程管理器杀死
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+	fmt.Println("\n收到退出信号，开始优雅关闭...")
+	server.Shutdown()
 }
 
-// sendMessage 向单个客户端发送消息
-func (c *Client) sendMessage(message string) {
-	select {
-	case c.msgChan <- message:
+// newHistoryStore 按 --history 的值构造对应的历史存储后端
+func newHistoryStore(backend, path string, sync bool) (history.Store, error) {
+	switch backend {
+	case "memory":
+		return history.NewMemoryStore(200), nil
+	case "file":
+		return history.NewFileStoreWithSync(path, sync)
+	case "sqlite":
+		return history.NewSQLiteStore(path)
 	default:
-		// 队列满，丢弃消息或关闭连接
+		return nil, fmt.Errorf("未知的历史存储后端 %q（可选 memory|file|sqlite）", backend)
 	}
-	// // 使用带缓冲的 writer 提高性能
-	// c.writer.WriteString(message)
-	// c.writer.Flush() // 立即刷新缓冲区，确保消息发送出去
-}
-
-// getCurrentTime 获取当前时间的格式化字符串
-func getCurrentTime() string {
-	return time.Now().Format("15:04:05")
 }
 
 /*
 【知识点总结】
 
 1. goroutine 实现并发：
-   - 每个客户端连接在独立的 goroutine 中处理
+   - 每个客户端连接在独立的 goroutine 中处理（tcpserver.Server.handleConn）
    - 不会互相阻塞，可以同时服务多个客户端
    - Go 的调度器会自动管理 goroutine
 
 2. 并发安全问题：
-   - 多个 goroutine 访问共享数据（clients map）
-   - 必须用 Mutex（互斥锁）保护，防止数据竞争
-   - Lock() 加锁，Unlock() 解锁，defer 确保一定会解锁
+   - 房间成员表、用户名索引、客户端昵称的并发读写都在 chat 包里处理，
+     这个文件不用再关心锁的细节
 
 3. 资源管理：
-   - defer 确保连接关闭和清理
-   - 从 clients map 中移除断开的客户端
+   - defer 确保连接关闭和清理（在 tcpserver.Server.handleConn 里）
+   - 从房间和用户名索引中移除断开的客户端
    - 防止内存泄漏
 
-4. 消息广播模式：
-   - 服务器作为中心节点
-   - 接收任意客户端的消息
-   - 转发给所有其他客户端
+4. 多房间模型：
+   - 每个客户端进入时默认在大厅（lobby）
+   - /join 把客户端从旧房间移除、加入新房间，旧房间和新房间各自收到广播
+   - 广播不再是全局的，而是 Room.Broadcast，只影响房间内成员
 
-【常见问题】
+5. 线上协议：
+   - 默认用 protocol 包的长度前缀帧，消息内容和边界不再依赖 '\n'
+   - --legacy 退回按行文本协议，仅用于兼容老的 telnet/nc 测试方式
 
-Q: 为什么需要 Mutex？
-A: map 不是并发安全的，多个 goroutine 同时读写会导致程序崩溃
+6. 心跳与优雅关闭：
+   - tcpserver.Server 持有 context + WaitGroup，Ctrl+C 触发 Shutdown：
+     停止 Accept、广播关服消息、等待在途连接退出，超时后强制关闭兜底
+   - 每个帧协议连接都有一个 ticker 定期发心跳，读超时会让半开的连接被及时清理；
+     --legacy 连接只靠 TCP keepalive 探活
+
+7. 可插拔的历史存储：
+   - hub.store 是 history.Store 接口，--history 决定具体用哪个实现
+     （内存环形缓冲区 / 按房间落盘的 JSON Lines 文件 / SQLite）
+   - 广播前先 record 一份到历史，客户端进房间时用 replayHistory 回放最近几条
+   - /search 直接查 hub.store，不经过在线成员表，离线也能搜到历史消息
+
+8. 和 ws-gateway 的关系：
+   - 房间、客户端、命令这套类型现在是 chat 包，TCP 传输细节是 tcpserver 包，
+     cmd/ws-gateway 用同一对包再接一个 WebSocket 网关，两边共享同一个
+     chat.Hub，telnet 和浏览器说的是同一间聊天室
+
+【常见问题】
 
-Q: 如果不用 goroutine 会怎样？
-A: 同一时间只能处理一个客户端，其他客户端会被阻塞
+Q: 这个文件和 cmd/ws-gateway 是什么关系？
+A: 以前这个文件自己维护一份 Client/Room/hub，ws-gateway 又维护一份更简单的
+   版本，两边行为会慢慢跑偏（比如心跳、优雅关闭只在这个文件里）。现在两边
+   都是 chat.Hub + tcpserver.Server 的薄封装，区别只是 ws-gateway 还多起了
+   一个 HTTP/WebSocket 监听器
 
-Q: 如何测试并发？
-A: 同时开启多个 telnet/nc 客户端，互相发送消息
+Q: 为什么要有 --legacy？
+A: 换成长度前缀帧之后，telnet/nc 发的纯文本就不再是合法帧了。
+   --legacy 在迁移期间保留老的按行协议，等大家都切到支持帧协议的客户端后可以去掉
 
-Q: 性能瓶颈在哪里？
-A: 这个简单实现中，广播消息时会持有锁，高并发时可能成为瓶颈
-   改进方案：使用 channel 进行消息传递（更高级的并发模式）
+Q: 为什么默认历史存储是 memory 而不是更"安全"的 file/sqlite？
+A: 保持默认 go run 零依赖、零落盘文件，符合这个文件一贯的教学 demo 定位；
+   需要持久化历史时显式加 --history=file 或 --history=sqlite 即可
 
 【实验建议】
 
-1. 同时运行 3-5 个客户端，观察消息广播
-2. 尝试断开某个客户端，看其他客户端是否收到通知
-3. 发送大量消息，观察服务器的并发处理能力
-4. 思考：如何添加私聊功能？如何限制消息长度？
+1. 开两个客户端，其中一个 /join 到新房间，观察消息是否还能互相看到
+2. 用 /list 确认房间和人数，用 /who 确认当前房间成员
+3. 用 /to 私聊，确认第三方看不到私聊内容
+4. 用 /nick 改成一个已存在的名字，确认会报错
 
 【下一步学习】
 理解了 TCP Socket 和并发处理后，可以学习 WebSocket 了
@@ -0,0 +1,288 @@
+// Package tcpserver 实现聊天引擎的 TCP 传输层：握手昵称、心跳、读超时、
+// 优雅关闭。房间和广播逻辑统一由 chat.Hub 提供，这样独立的 TCP 聊天 demo
+// 和内嵌在 ws-gateway 里的 TCP 入口用的是同一份实现，不再各自维护一份
+// 容易跑偏的拷贝（以前 02-tcp-chat-server.go 有自己的 hub/Client/Room，
+// ws-gateway 又有一份更简单的版本，心跳和优雅关闭只在前者身上，两边行为
+// 渐渐就对不上了）。
+package tcpserver
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Kirby980/websocket-demo/chat"
+	"github.com/Kirby980/websocket-demo/protocol"
+)
+
+// Config 是 Server 的可调参数；零值字段会在 New 里被换成合理的默认值。
+type Config struct {
+	Legacy        bool          // true 时使用旧版按行文本协议，兼容 telnet/nc
+	PingInterval  time.Duration // 心跳间隔
+	ReadTimeout   time.Duration // 读超时：非 legacy 模式下超过这个时间没收到消息就断开
+	ShutdownGrace time.Duration // 优雅关闭时等待客户端自然退出的时间
+	MaxClients    int32         // 最大同时在线人数
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = 30 * time.Second
+	}
+	if cfg.ReadTimeout <= 0 {
+		cfg.ReadTimeout = 90 * time.Second
+	}
+	if cfg.ShutdownGrace <= 0 {
+		cfg.ShutdownGrace = 5 * time.Second
+	}
+	if cfg.MaxClients <= 0 {
+		cfg.MaxClients = 1000
+	}
+	return cfg
+}
+
+// Server 把一个 chat.Hub 暴露为 TCP 监听器：每个连接握手昵称之后变成一个
+// *chat.Client 接入 hub，和其他传输层（比如 wsgateway）共享同一批房间。
+type Server struct {
+	listener net.Listener
+	hub      *chat.Hub
+	cfg      Config
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	clientCount int32
+}
+
+// New 创建一个绑定到 listener 和 hub 的 Server。
+func New(listener net.Listener, hub *chat.Hub, cfg Config) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		listener: listener,
+		hub:      hub,
+		cfg:      cfg.withDefaults(),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Serve 循环接受连接，直到 Shutdown 关闭 listener。阻塞调用，通常用
+// go server.Serve() 启动。
+func (s *Server) Serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.ctx.Done():
+				return // 正在优雅关闭，listener 是被我们自己关掉的
+			default:
+			}
+			fmt.Printf("接受连接失败: %v\n", err)
+			continue
+		}
+
+		if atomic.LoadInt32(&s.clientCount) >= s.cfg.MaxClients {
+			s.writeReject(conn, "错误：服务器已满，请稍后再试\n")
+			conn.Close()
+			continue
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(conn)
+		}()
+	}
+}
+
+// Shutdown 停止接受新连接、广播关服公告，并在 ShutdownGrace 内等待所有
+// 客户端 goroutine 自然退出；超时的话强制断开剩余连接再等一次。
+func (s *Server) Shutdown() {
+	s.cancel()
+	s.listener.Close()
+	s.hub.BroadcastAll("系统消息：服务器即将关闭，连接会被断开\n")
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		fmt.Println("所有客户端已正常断开")
+	case <-time.After(s.cfg.ShutdownGrace):
+		fmt.Println("优雅关闭超时，强制断开剩余连接")
+		s.hub.CloseAllConns()
+		<-done
+	}
+}
+
+// writeReject 把一条拒绝理由发给还没注册进 hub（或者压根没握手成功）的连接。
+// 非 legacy 模式下也必须按协议帧格式写出去——这类连接的 ReadEnvelope 循环
+// 会把收到的第一个东西当成一帧来解析，如果这里直接写裸文本，帧头 4 个字节
+// 会被当成帧长度，对方要么读出乱码要么直接判为坏帧断开，看不到拒绝原因。
+func (s *Server) writeReject(conn net.Conn, text string) {
+	if s.cfg.Legacy {
+		conn.Write([]byte(text))
+		return
+	}
+	env := protocol.NewTextEnvelope("message", "", "", text, time.Now().Unix())
+	protocol.WriteEnvelope(conn, env)
+}
+
+// handleConn 处理单个连接：握手昵称、注册进 hub、收发循环，直到断开。
+func (s *Server) handleConn(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	name, err := s.readHandshakeLine(reader)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	name = strings.TrimSpace(name)
+
+	client := chat.NewClient(name, func(env *protocol.Envelope) error {
+		if s.cfg.Legacy {
+			if _, err := writer.WriteString(env.Text()); err != nil {
+				return err
+			}
+			return writer.Flush()
+		}
+		if err := protocol.WriteEnvelope(writer, env); err != nil {
+			return err
+		}
+		return writer.Flush()
+	})
+	client.SetCloser(conn.Close)
+
+	if err := s.hub.Register(name, client); err != nil {
+		s.writeReject(conn, fmt.Sprintf("错误：%v，请重新连接\n", err))
+		conn.Close()
+		return
+	}
+
+	atomic.AddInt32(&s.clientCount, 1)
+	defer atomic.AddInt32(&s.clientCount, -1)
+
+	go client.WritePump()
+	defer client.Close()
+
+	fmt.Printf("[%s] %s 加入聊天室，来自 %s\n", timestamp(), name, conn.RemoteAddr())
+	client.SendMessage(fmt.Sprintf("欢迎来到聊天室！你的名字是：%s\n", name))
+	s.hub.Welcome(client)
+
+	// leaveReason 默认是正常离开；framedReadLoop 在读超时断开时会把它改写成
+	// "因超时断开"，这样房间里其他人能分清这是被服务器当成半开连接踢掉，
+	// 还是对方自己 /quit 或者断线了。
+	leaveReason := "离开了聊天室"
+	defer func() {
+		s.hub.LeaveWithReason(client, leaveReason)
+		fmt.Printf("[%s] %s 离开聊天室\n", timestamp(), client.Name())
+	}()
+
+	if s.cfg.Legacy {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetKeepAlive(true)
+			tcpConn.SetKeepAlivePeriod(s.cfg.PingInterval)
+		}
+		s.legacyReadLoop(client, reader)
+		return
+	}
+
+	stopPing := make(chan struct{})
+	defer close(stopPing)
+	go s.pinger(client, stopPing)
+
+	s.framedReadLoop(client, conn, reader, &leaveReason)
+}
+
+// pinger 定期给客户端发一条心跳信封，直到 stop 被关闭。心跳走 c.Ping()
+// 而不是 c.SendMessage("ping\n")，否则每次心跳都会变成一条 Type 为
+// "message" 的聊天消息，被客户端当成别人发的 "ping" 打印出来。
+func (s *Server) pinger(c *chat.Client, stop <-chan struct{}) {
+	ticker := time.NewTicker(s.cfg.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.Ping()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// legacyReadLoop 按行读取客户端消息，没有读超时，靠 TCP keepalive 探活
+func (s *Server) legacyReadLoop(c *chat.Client, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fmt.Printf("[%s] %s: %s\n", timestamp(), c.Name(), line)
+		if !s.hub.HandleLine(c, line) {
+			return
+		}
+	}
+}
+
+// framedReadLoop 读取协议帧，每次读之前刷新读超时；超过 ReadTimeout 没有
+// 收到任何一帧（聊天消息或者 pong）就视为连接已断开。leaveReason 在超时
+// 发生时会被改写，调用方 handleConn 的 defer 用它来决定广播什么离开原因。
+//
+// 这里的读超时是靠 ping/pong 往返判断连接是否存活，而不是只看对方有没有
+// 主动发过聊天消息——一个只读不发的空闲客户端每隔 PingInterval 会收到一条
+// 心跳并回一个 pong，读超时因此被正常续上；只有真的半开（pong 也收不到）
+// 的连接才会在 ReadTimeout 后被断开。
+func (s *Server) framedReadLoop(c *chat.Client, conn net.Conn, reader *bufio.Reader, leaveReason *string) {
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.cfg.ReadTimeout))
+		env, err := protocol.ReadEnvelope(reader, protocol.DefaultMaxFrameSize)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				fmt.Printf("[%s] %s 读超时（ping/pong 也没收到），视为连接已断开\n", timestamp(), c.Name())
+				*leaveReason = "因超时断开"
+			}
+			return
+		}
+		if env.Type == "pong" {
+			continue // 只用来续命，不当聊天消息处理
+		}
+
+		line := strings.TrimSpace(env.Text())
+		if line == "" {
+			continue
+		}
+		fmt.Printf("[%s] %s: %s\n", timestamp(), c.Name(), line)
+		if !s.hub.HandleLine(c, line) {
+			return
+		}
+	}
+}
+
+// readHandshakeLine 读取连接建立后的第一行/第一帧（约定为用户名）
+func (s *Server) readHandshakeLine(reader *bufio.Reader) (string, error) {
+	if s.cfg.Legacy {
+		return reader.ReadString('\n')
+	}
+	env, err := protocol.ReadEnvelope(reader, protocol.DefaultMaxFrameSize)
+	if err != nil {
+		return "", err
+	}
+	return env.Text(), nil
+}
+
+func timestamp() string {
+	return time.Now().Format("15:04:05")
+}
@@ -0,0 +1,166 @@
+// Package wsgateway 把浏览器的 WebSocket 连接接入 chat 包描述的聊天引擎，
+// 让同一个 Hub 里既有 TCP 客户端也有浏览器客户端——对聊天室来说它们没有区别，
+// 都只是挂在 msgChan 上的一个 *chat.Client。
+package wsgateway
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Kirby980/websocket-demo/chat"
+	"github.com/Kirby980/websocket-demo/protocol"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// PingInterval 是网关向浏览器发送 ping 帧的间隔
+	PingInterval = 30 * time.Second
+	// PongWait 是收不到浏览器 pong 响应就判定连接已死的超时时间
+	PongWait = 60 * time.Second
+)
+
+// Gateway 持有一个共享的 chat.Hub，并把它暴露为 HTTP + WebSocket 服务。
+type Gateway struct {
+	hub      *chat.Hub
+	upgrader websocket.Upgrader
+}
+
+// New 创建一个绑定到 hub 的网关。hub 通常也同时被 TCP 服务器持有，
+// 这样 WebSocket 和 TCP 客户端才能看到同一批房间和同一批在线用户。
+func New(hub *chat.Hub) *Gateway {
+	return &Gateway{
+		hub: hub,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			// 演示用途：允许任意来源跨域升级
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+// Handler 返回网关的 http.Handler：“/” 提供一个极简的聊天页面，
+// “/ws” 把连接升级为 WebSocket。
+func (g *Gateway) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", g.serveIndex)
+	mux.HandleFunc("/ws", g.serveWS)
+	return mux
+}
+
+func (g *Gateway) serveIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(indexHTML))
+}
+
+// serveWS 把一次 HTTP 请求升级为 WebSocket，并把这条连接接入聊天室。
+func (g *Gateway) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("websocket 升级失败: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// 第一条消息约定为用户名，和 TCP 那边“连接后先发一行名字”的握手方式对齐。
+	_, nameBytes, err := conn.ReadMessage()
+	if err != nil {
+		return
+	}
+	name := string(nameBytes)
+
+	// WebSocket 连接本身已经有消息边界，不需要再套一层长度前缀帧，
+	// 直接把 Envelope 里的文本当成一条 WS 文本消息发出去即可。
+	client := chat.NewClient(name, func(env *protocol.Envelope) error {
+		return conn.WriteMessage(websocket.TextMessage, []byte(env.Text()))
+	})
+
+	if err := g.hub.Register(name, client); err != nil {
+		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("错误：%v，请换个名字重连\n", err)))
+		return
+	}
+
+	go client.WritePump()
+	defer client.Close()
+
+	client.SendMessage(fmt.Sprintf("欢迎来到聊天室！你的名字是：%s\n", client.Name()))
+	g.hub.Welcome(client)
+	defer g.hub.Leave(client)
+
+	// keepalive：定期 ping，pong 回来就把读超时往后推；浏览器断线或卡死时
+	// 读超时会让下面的 ReadMessage 报错，从而清理这个客户端。
+	conn.SetReadDeadline(time.Now().Add(PongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(PongWait))
+		return nil
+	})
+
+	stopPing := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					return
+				}
+			case <-stopPing:
+				return
+			}
+		}
+	}()
+	defer close(stopPing)
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if !g.hub.HandleLine(client, string(msg)) {
+			return
+		}
+	}
+}
+
+const indexHTML = `<!DOCTYPE html>
+<html lang="zh">
+<head>
+  <meta charset="utf-8">
+  <title>WebSocket 聊天室</title>
+</head>
+<body>
+  <h1>WebSocket 聊天室</h1>
+  <div>
+    <input id="name" placeholder="你的名字">
+    <button onclick="connect()">连接</button>
+  </div>
+  <pre id="log" style="height:300px;overflow-y:scroll;border:1px solid #ccc;padding:4px;"></pre>
+  <input id="msg" placeholder="输入消息或 /命令，回车发送" style="width:300px;" onkeydown="if(event.key==='Enter')send()">
+  <button onclick="send()">发送</button>
+  <script>
+    let ws;
+    function log(line) {
+      const el = document.getElementById('log');
+      el.textContent += line + "\n";
+      el.scrollTop = el.scrollHeight;
+    }
+    function connect() {
+      const name = document.getElementById('name').value || 'guest';
+      ws = new WebSocket((location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + '/ws');
+      ws.onopen = () => ws.send(name);
+      ws.onmessage = (e) => log(e.data);
+      ws.onclose = () => log('[连接已关闭]');
+    }
+    function send() {
+      const input = document.getElementById('msg');
+      if (ws && ws.readyState === WebSocket.OPEN && input.value) {
+        ws.send(input.value);
+        input.value = '';
+      }
+    }
+  </script>
+</body>
+</html>`